@@ -0,0 +1,152 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/cipher/encoder"
+)
+
+// blobsBucket is the single bucket BoltDB stores every blob in, keyed by
+// its SHA256 digest
+var blobsBucket = []byte("blobs")
+
+// errStopIterate is used internally to break out of a bolt.Bucket.ForEach
+// once IterateFunc asks us to stop
+var errStopIterate = fmt.Errorf("data: stop iteration")
+
+// BoltDB is a persistent, content-addressable IDataSource backed by
+// BoltDB. Add writes inside a transaction only if the key is absent yet,
+// matching MemoryDB.Add semantics; Update, like MemoryDB.Update, writes
+// unconditionally, and Delete removes blobs
+type BoltDB struct {
+	bolt *bolt.DB
+}
+
+// NewBoltDB opens (creating if necessary) a BoltDB-backed database at path
+func NewBoltDB(path string) (db *BoltDB, err error) {
+	var b *bolt.DB
+	if b, err = bolt.Open(path, 0644, nil); err != nil {
+		return
+	}
+	err = b.Update(func(tx *bolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists(blobsBucket)
+		return
+	})
+	if err != nil {
+		b.Close()
+		return
+	}
+	db = &BoltDB{bolt: b}
+	return
+}
+
+// Close closes the underlying bolt database
+func (d *BoltDB) Close() error {
+	return d.bolt.Close()
+}
+
+func (d *BoltDB) Save(value interface{}) cipher.SHA256 {
+	return d.Update(encoder.Serialize(value))
+}
+
+func (d *BoltDB) Update(data []byte) cipher.SHA256 {
+	key := createKey(data)
+	if key == (cipher.SHA256{}) || data == nil {
+		panic("Invalid key")
+	}
+	err := d.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blobsBucket).Put(key[:], data)
+	})
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (d *BoltDB) Add(key cipher.SHA256, value []byte) (err error) {
+	if key == (cipher.SHA256{}) || value == nil {
+		panic("Invalid key")
+	}
+	return d.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(blobsBucket)
+		if b.Get(key[:]) != nil {
+			return fmt.Errorf("key already present: %v", key)
+		}
+		return b.Put(key[:], value)
+	})
+}
+
+func (d *BoltDB) Has(key cipher.SHA256) (ok bool) {
+	d.bolt.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(blobsBucket).Get(key[:]) != nil
+		return nil
+	})
+	return
+}
+
+func (d *BoltDB) Get(key cipher.SHA256) (v []byte, ok bool) {
+	d.bolt.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(blobsBucket).Get(key[:])
+		if raw == nil {
+			return nil
+		}
+		v = make([]byte, len(raw))
+		copy(v, raw)
+		ok = true
+		return nil
+	})
+	return
+}
+
+func (d *BoltDB) Where(q QueryFunc) (result []cipher.SHA256) {
+	d.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blobsBucket).ForEach(func(k, v []byte) error {
+			var key cipher.SHA256
+			copy(key[:], k)
+			if q(key, v) {
+				result = append(result, key)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func (d *BoltDB) Statistic() (s Statistic) {
+	d.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blobsBucket).ForEach(func(k, v []byte) error {
+			s.Total++
+			s.Memory += len(v)
+			return nil
+		})
+	})
+	return
+}
+
+// Iterate runs fn for every stored key/value pair inside a single read
+// transaction, stopping early if fn returns false
+func (d *BoltDB) Iterate(fn IterateFunc) {
+	d.bolt.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(blobsBucket).ForEach(func(k, v []byte) error {
+			var key cipher.SHA256
+			copy(key[:], k)
+			if !fn(key, v) {
+				return errStopIterate
+			}
+			return nil
+		})
+		if err == errStopIterate {
+			return nil
+		}
+		return err
+	})
+}
+
+// Delete removes the blob stored under key, if any
+func (d *BoltDB) Delete(key cipher.SHA256) error {
+	return d.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blobsBucket).Delete(key[:])
+	})
+}
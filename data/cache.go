@@ -0,0 +1,238 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// OnEvictFunc is called with a blob's key and value right before CacheDB
+// drops it to make room. Returning false refuses the eviction, so the
+// cache grows past its ceiling instead of dropping something the caller
+// still needs, e.g. a blob currently referenced by Container.Root
+type OnEvictFunc func(key cipher.SHA256, value []byte) (allow bool)
+
+type cacheEntry struct {
+	key   cipher.SHA256
+	value []byte
+	hot   bool
+}
+
+// CacheDB wraps an IDataSource with an LRU cache bounded by MaxBytes and
+// MaxItems, whichever is hit first. A small "hot" segment is kept apart
+// from the main, cold LRU list so that a single Where full scan doesn't
+// trash the working set (loosely modeled on 2Q/ARC): an entry only earns
+// a spot in hot once it's touched a second time, and hot entries get one
+// more lap through cold before they're actually evicted
+type CacheDB struct {
+	sync.Mutex
+	backend IDataSource
+
+	maxBytes int
+	maxItems int
+	curBytes int
+	hotCap   int
+
+	hot  *list.List
+	cold *list.List
+	idx  map[cipher.SHA256]*list.Element
+
+	// OnEvict, if set, is consulted before every eviction
+	OnEvict OnEvictFunc
+}
+
+// NewCacheDB wraps backend with an LRU cache capped at maxBytes total
+// payload size and maxItems entries. A zero value for either means
+// "unbounded" on that dimension
+func NewCacheDB(backend IDataSource, maxBytes, maxItems int) *CacheDB {
+	c := &CacheDB{
+		backend:  backend,
+		maxBytes: maxBytes,
+		maxItems: maxItems,
+		hot:      list.New(),
+		cold:     list.New(),
+		idx:      make(map[cipher.SHA256]*list.Element),
+	}
+	if maxItems > 0 {
+		if c.hotCap = maxItems / 4; c.hotCap == 0 {
+			c.hotCap = 1
+		}
+	} else {
+		c.hotCap = 256
+	}
+	return c
+}
+
+func (c *CacheDB) Save(value interface{}) cipher.SHA256 {
+	key := c.backend.Save(value)
+	if v, ok := c.backend.Get(key); ok {
+		c.insert(key, v)
+	}
+	return key
+}
+
+func (c *CacheDB) Update(data []byte) cipher.SHA256 {
+	key := c.backend.Update(data)
+	c.insert(key, data)
+	return key
+}
+
+func (c *CacheDB) Add(key cipher.SHA256, value []byte) error {
+	if err := c.backend.Add(key, value); err != nil {
+		return err
+	}
+	c.insert(key, value)
+	return nil
+}
+
+func (c *CacheDB) Has(key cipher.SHA256) bool {
+	c.Lock()
+	el, ok := c.idx[key]
+	if ok {
+		c.touch(el)
+	}
+	c.Unlock()
+	if ok {
+		return true
+	}
+	return c.backend.Has(key)
+}
+
+func (c *CacheDB) Get(key cipher.SHA256) ([]byte, bool) {
+	c.Lock()
+	if el, ok := c.idx[key]; ok {
+		c.touch(el)
+		v := el.Value.(*cacheEntry).value
+		c.Unlock()
+		return v, true
+	}
+	c.Unlock()
+
+	v, ok := c.backend.Get(key)
+	if ok {
+		c.insert(key, v)
+	}
+	return v, ok
+}
+
+// Where bypasses the cache entirely: a full scan is exactly the kind of
+// access pattern the hot/cold split exists to protect the cache from
+func (c *CacheDB) Where(q QueryFunc) []cipher.SHA256 {
+	return c.backend.Where(q)
+}
+
+func (c *CacheDB) Statistic() Statistic {
+	return c.backend.Statistic()
+}
+
+func (c *CacheDB) Iterate(fn IterateFunc) {
+	c.backend.Iterate(fn)
+}
+
+func (c *CacheDB) Delete(key cipher.SHA256) error {
+	if err := c.backend.Delete(key); err != nil {
+		return err
+	}
+	c.Lock()
+	if el, ok := c.idx[key]; ok {
+		c.removeElement(el)
+	}
+	c.Unlock()
+	return nil
+}
+
+func (c *CacheDB) insert(key cipher.SHA256, value []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.idx[key]; ok {
+		c.touch(el)
+		return
+	}
+
+	entry := &cacheEntry{key: key, value: value}
+	el := c.cold.PushFront(entry)
+	c.idx[key] = el
+	c.curBytes += len(value)
+
+	c.evict()
+}
+
+// touch promotes an entry to MRU, moving it into the hot segment once
+// it's been referenced while already cached
+func (c *CacheDB) touch(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	if entry.hot {
+		c.hot.MoveToFront(el)
+		return
+	}
+	c.cold.Remove(el)
+	entry.hot = true
+	c.idx[entry.key] = c.hot.PushFront(entry)
+	if c.hot.Len() > c.hotCap {
+		c.demoteOldest()
+	}
+}
+
+// demoteOldest pushes the LRU entry of the hot segment back into cold
+// instead of evicting it outright, giving it one more lap before it can
+// actually be dropped
+func (c *CacheDB) demoteOldest() {
+	back := c.hot.Back()
+	if back == nil {
+		return
+	}
+	entry := back.Value.(*cacheEntry)
+	c.hot.Remove(back)
+	entry.hot = false
+	c.idx[entry.key] = c.cold.PushFront(entry)
+}
+
+// evict drops cold entries, oldest first, until both configured ceilings
+// are satisfied again, consulting OnEvict before every drop. A refused
+// entry is moved to the front of its list rather than aborting the pass,
+// since it's by definition the one that keeps sinking back to the tail
+// and would otherwise block every later candidate from ever being tried
+func (c *CacheDB) evict() {
+	refused := 0
+	for (c.maxBytes > 0 && c.curBytes > c.maxBytes) ||
+		(c.maxItems > 0 && len(c.idx) > c.maxItems) {
+
+		if refused >= len(c.idx) {
+			return // every remaining entry was refused this pass; give up
+		}
+
+		back := c.cold.Back()
+		hot := false
+		if back == nil {
+			back = c.hot.Back() // nothing cold left; dip into hot
+			hot = true
+			if back == nil {
+				return // cache is empty but still "over" budget; give up
+			}
+		}
+		entry := back.Value.(*cacheEntry)
+		if c.OnEvict != nil && !c.OnEvict(entry.key, entry.value) {
+			if hot {
+				c.hot.MoveToFront(back)
+			} else {
+				c.cold.MoveToFront(back)
+			}
+			refused++
+			continue
+		}
+		c.removeElement(back)
+	}
+}
+
+func (c *CacheDB) removeElement(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	if entry.hot {
+		c.hot.Remove(el)
+	} else {
+		c.cold.Remove(el)
+	}
+	delete(c.idx, entry.key)
+	c.curBytes -= len(entry.value)
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// TestCacheDBEvictSkipsRefusedEntry guards against a single pinned entry
+// blocking every later eviction candidate: once OnEvict refuses the
+// oldest entry, evict must move on to the next-oldest one rather than
+// aborting the whole pass.
+func TestCacheDBEvictSkipsRefusedEntry(t *testing.T) {
+	backend := NewMemoryDB()
+	c := NewCacheDB(backend, 0, 2)
+
+	pinned := c.Update([]byte("pinned"))
+
+	c.OnEvict = func(key cipher.SHA256, value []byte) bool {
+		return key != pinned
+	}
+
+	for i := 0; i < 5; i++ {
+		c.Update([]byte{byte(i)})
+	}
+
+	// check the cache itself: Has would fall back to the backend
+	if _, cached := c.idx[pinned]; !cached {
+		t.Fatalf("pinned entry was evicted despite OnEvict refusing it")
+	}
+	if len(c.idx) > c.maxItems {
+		t.Fatalf("cache has %d items, want at most %d: eviction got stuck on the refused entry", len(c.idx), c.maxItems)
+	}
+}
+
+// TestCacheDBHasPromotesToHot guards against Has being a cold read: like
+// Get, a Has hit must bump the entry to MRU (and into the hot segment),
+// otherwise an existence-check-before-fetch access pattern never warms
+// anything and the entry keeps sinking toward eviction.
+func TestCacheDBHasPromotesToHot(t *testing.T) {
+	backend := NewMemoryDB()
+	c := NewCacheDB(backend, 0, 2)
+
+	key := c.Update([]byte("warm"))
+
+	if !c.Has(key) {
+		t.Fatalf("Has reported missing entry right after insert")
+	}
+
+	for i := 0; i < 5; i++ {
+		c.Update([]byte{byte(i)})
+	}
+
+	// check the cache itself: Has would fall back to the backend
+	if _, cached := c.idx[key]; !cached {
+		t.Fatalf("entry repeatedly Has-checked was evicted: Has must bump it to MRU like Get does")
+	}
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// backends returns one constructor per IDataSource implementation that
+// should behave identically for the basic Save/Add/Has/Get/Where/
+// Statistic/Iterate/Delete contract. Each constructor also returns a
+// cleanup func to run once the test is done with it
+func backends(t *testing.T) map[string]func() (IDataSource, func()) {
+	return map[string]func() (IDataSource, func()){
+		"MemoryDB": func() (IDataSource, func()) {
+			return NewMemoryDB(), func() {}
+		},
+		"BoltDB": func() (IDataSource, func()) {
+			dir, err := os.MkdirTemp("", "cxo-data-test")
+			if err != nil {
+				t.Fatalf("MkdirTemp: %v", err)
+			}
+			db, err := NewBoltDB(filepath.Join(dir, "data.db"))
+			if err != nil {
+				os.RemoveAll(dir)
+				t.Fatalf("NewBoltDB: %v", err)
+			}
+			return db, func() {
+				db.Close()
+				os.RemoveAll(dir)
+			}
+		},
+	}
+}
+
+// TestBackendsAgreeOnIDataSource runs the same sequence of operations
+// against every IDataSource implementation and checks they all produce
+// the same observable results, guarding against a persistent backend
+// silently drifting from MemoryDB's semantics.
+func TestBackendsAgreeOnIDataSource(t *testing.T) {
+	for name, newBackend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			db, cleanup := newBackend()
+			defer cleanup()
+
+			key := db.Save([]byte("first"))
+			if !db.Has(key) {
+				t.Fatalf("Has(%v) = false right after Save", key)
+			}
+			v, ok := db.Get(key)
+			if !ok || string(v) != "first" {
+				t.Fatalf("Get(%v) = %q, %v; want %q, true", key, v, ok, "first")
+			}
+
+			added := createKey([]byte("second"))
+			if err := db.Add(added, []byte("second")); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if err := db.Add(added, []byte("second")); err == nil {
+				t.Fatalf("Add of an already-present key did not error")
+			}
+
+			stat := db.Statistic()
+			if stat.Total != 2 {
+				t.Fatalf("Statistic().Total = %d, want 2", stat.Total)
+			}
+			if stat.Memory != len("first")+len("second") {
+				t.Fatalf("Statistic().Memory = %d, want %d", stat.Memory, len("first")+len("second"))
+			}
+
+			found := db.Where(func(k cipher.SHA256, v []byte) bool {
+				return string(v) == "second"
+			})
+			if len(found) != 1 || found[0] != added {
+				t.Fatalf("Where matched %v, want [%v]", found, added)
+			}
+
+			seen := make(map[cipher.SHA256][]byte)
+			db.Iterate(func(k cipher.SHA256, v []byte) bool {
+				seen[k] = v
+				return true
+			})
+			if len(seen) != 2 || string(seen[key]) != "first" || string(seen[added]) != "second" {
+				t.Fatalf("Iterate saw %v, want both entries", seen)
+			}
+
+			if err := db.Delete(key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if db.Has(key) {
+				t.Fatalf("Has(%v) = true after Delete", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package data
+
+import (
+	"crypto/aes"
+	stdcipher "crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/cipher/encoder"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	masterKeySize = 32
+	nonceSize     = aes.BlockSize
+	macSize       = sha256.Size
+)
+
+var (
+	// ErrWrongPassphrase occurs when a keyfile fails to decrypt, almost
+	// always because the passphrase is wrong
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keyfile")
+	// ErrMACMismatch occurs when a stored blob fails MAC verification,
+	// meaning it was corrupted, tampered with, or encrypted under a
+	// different master key
+	ErrMACMismatch = errors.New("encrypted blob failed MAC verification")
+)
+
+// EncryptedDB wraps any IDataSource and encrypts every blob before it
+// reaches the backend, so a container can be stored on untrusted disks or
+// shared between peers without leaking content. The on-disk index key is
+// HMAC(masterKey, plaintextDigest) rather than the raw SHA256, so the
+// backend never sees the canonical content hash either. Where, Statistic
+// and Iterate decrypt every entry they touch and report it under its
+// canonical digest, the same key space Get/Has/Add/Delete use
+type EncryptedDB struct {
+	backend   IDataSource
+	masterKey [masterKeySize]byte
+}
+
+// NewEncryptedDB wraps ds, encrypting everything that passes through it
+// with keys derived from masterKey
+func NewEncryptedDB(ds IDataSource, masterKey [masterKeySize]byte) *EncryptedDB {
+	return &EncryptedDB{backend: ds, masterKey: masterKey}
+}
+
+// OpenEncrypted opens (creating if necessary) a BoltDB at path, wrapped in
+// an EncryptedDB keyed by the master key stored in path+".key", unwrapped
+// with passphrase. A fresh keyfile is generated the first time path+".key"
+// doesn't exist
+func OpenEncrypted(path, passphrase string) (ed *EncryptedDB, err error) {
+	keyfilePath := path + ".key"
+
+	kf, err := LoadKeyfile(keyfilePath)
+	if os.IsNotExist(err) {
+		if kf, err = GenerateKeyfile(passphrase); err != nil {
+			return
+		}
+		if err = kf.Save(keyfilePath); err != nil {
+			return
+		}
+	} else if err != nil {
+		return
+	}
+
+	masterKey, err := kf.Unwrap(passphrase)
+	if err != nil {
+		return
+	}
+
+	bdb, err := NewBoltDB(path)
+	if err != nil {
+		return
+	}
+	ed = NewEncryptedDB(bdb, masterKey)
+	return
+}
+
+func (e *EncryptedDB) indexKey(digest cipher.SHA256) (key cipher.SHA256) {
+	mac := hmac.New(sha256.New, e.masterKey[:])
+	mac.Write(digest[:])
+	copy(key[:], mac.Sum(nil))
+	return
+}
+
+// wrappedCEKSize is the fixed on-disk size of a wrapped content-encryption
+// key: nonce || 32-byte CEK || mac
+const wrappedCEKSize = nonceSize + 32 + macSize
+
+// keyWrapKey is the fixed key used to wrap (and unwrap) every per-blob
+// content-encryption key (the CEK itself is random per blob). It does not
+// depend on the plaintext digest: the digest isn't known yet when decrypting (that's
+// the whole point of iterating), so deriving it from the digest would
+// make every entry undecipherable without already knowing what it is
+func (e *EncryptedDB) keyWrapKey() (kek [32]byte) {
+	mac := hmac.New(sha256.New, e.masterKey[:])
+	mac.Write([]byte("cxo/kek"))
+	copy(kek[:], mac.Sum(nil))
+	return
+}
+
+// aesCTRSeal encrypts plain under key with a fresh random nonce and MACs
+// nonce||ciphertext, returning nonce||ciphertext||mac
+func aesCTRSeal(key, plain []byte) (sealed []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	ciphertext := make([]byte, len(plain))
+	stdcipher.NewCTR(block, nonce).XORKeyStream(ciphertext, plain)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	tag := mac.Sum(nil)
+
+	sealed = make([]byte, 0, len(nonce)+len(ciphertext)+len(tag))
+	sealed = append(sealed, nonce...)
+	sealed = append(sealed, ciphertext...)
+	sealed = append(sealed, tag...)
+	return
+}
+
+// aesCTROpen reverses aesCTRSeal, verifying the MAC before decrypting
+func aesCTROpen(key, sealed []byte) (plain []byte, err error) {
+	if len(sealed) < nonceSize+macSize {
+		err = ErrMACMismatch
+		return
+	}
+	nonce := sealed[:nonceSize]
+	ciphertext := sealed[nonceSize : len(sealed)-macSize]
+	tag := sealed[len(sealed)-macSize:]
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		err = ErrMACMismatch
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	plain = make([]byte, len(ciphertext))
+	stdcipher.NewCTR(block, nonce).XORKeyStream(plain, ciphertext)
+	return
+}
+
+// seal encrypts plain under a fresh, random content-encryption key, which
+// it wraps with keyWrapKey, and returns the digest of the plaintext (the
+// logical key callers address it by) together with the sealed form
+// wrappedCEK||nonce||ciphertext||mac to hand to the backend
+func (e *EncryptedDB) seal(plain []byte) (digest cipher.SHA256, sealed []byte, err error) {
+	digest = cipher.SumSHA256(plain)
+
+	cek := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, cek); err != nil {
+		return
+	}
+
+	kek := e.keyWrapKey()
+	var wrappedCEK []byte
+	if wrappedCEK, err = aesCTRSeal(kek[:], cek); err != nil {
+		return
+	}
+
+	var payload []byte
+	if payload, err = aesCTRSeal(cek, plain); err != nil {
+		return
+	}
+
+	sealed = make([]byte, 0, len(wrappedCEK)+len(payload))
+	sealed = append(sealed, wrappedCEK...)
+	sealed = append(sealed, payload...)
+	return
+}
+
+// open reverses seal: it unwraps the per-blob content-encryption key
+// (which doesn't require knowing the digest up front) and decrypts the
+// payload, returning the plaintext together with its digest
+func (e *EncryptedDB) open(sealed []byte) (digest cipher.SHA256, plain []byte, err error) {
+	if len(sealed) < wrappedCEKSize {
+		err = ErrMACMismatch
+		return
+	}
+
+	kek := e.keyWrapKey()
+	var cek []byte
+	if cek, err = aesCTROpen(kek[:], sealed[:wrappedCEKSize]); err != nil {
+		return
+	}
+
+	if plain, err = aesCTROpen(cek, sealed[wrappedCEKSize:]); err != nil {
+		return
+	}
+	digest = cipher.SumSHA256(plain)
+	return
+}
+
+func (e *EncryptedDB) Save(value interface{}) cipher.SHA256 {
+	return e.Update(encoder.Serialize(value))
+}
+
+func (e *EncryptedDB) Update(plain []byte) (digest cipher.SHA256) {
+	digest, sealed, err := e.seal(plain)
+	if err != nil {
+		panic(err)
+	}
+	// Add, not the backend's Update: we key by HMAC(masterKey, digest),
+	// not by a hash of the ciphertext. A matching idx already present
+	// just means this exact plaintext was sealed before, which Add
+	// reports as an error we can ignore; any other error means the
+	// backend actually failed to write, which Update has no way to
+	// report to its caller, so it panics like the other backends do
+	idx := e.indexKey(digest)
+	if err = e.backend.Add(idx, sealed); err != nil && !e.backend.Has(idx) {
+		panic(err)
+	}
+	return
+}
+
+func (e *EncryptedDB) Add(key cipher.SHA256, plain []byte) (err error) {
+	digest, sealed, err := e.seal(plain)
+	if err != nil {
+		return
+	}
+	if digest != key {
+		return fmt.Errorf("data: key does not match content digest: %v != %v", key, digest)
+	}
+	return e.backend.Add(e.indexKey(digest), sealed)
+}
+
+func (e *EncryptedDB) Has(key cipher.SHA256) bool {
+	return e.backend.Has(e.indexKey(key))
+}
+
+func (e *EncryptedDB) Get(key cipher.SHA256) (plain []byte, ok bool) {
+	sealed, ok := e.backend.Get(e.indexKey(key))
+	if !ok {
+		return
+	}
+	digest, p, err := e.open(sealed)
+	if err != nil || digest != key {
+		return nil, false
+	}
+	return p, true
+}
+
+func (e *EncryptedDB) Delete(key cipher.SHA256) error {
+	return e.backend.Delete(e.indexKey(key))
+}
+
+// Where decrypts every stored blob to evaluate q against the plaintext,
+// reporting the canonical digest as the key — Get's contract — rather
+// than the backend's HMAC index key
+func (e *EncryptedDB) Where(q QueryFunc) (result []cipher.SHA256) {
+	e.backend.Iterate(func(_ cipher.SHA256, sealed []byte) bool {
+		digest, plain, err := e.open(sealed)
+		if err != nil {
+			return true // foreign or corrupt entry, skip and keep scanning
+		}
+		if q(digest, plain) {
+			result = append(result, digest)
+		}
+		return true
+	})
+	return
+}
+
+// Statistic reports Total/Memory over decrypted plaintext, matching the
+// contract the rest of IDataSource exposes
+func (e *EncryptedDB) Statistic() (s Statistic) {
+	e.backend.Iterate(func(_ cipher.SHA256, sealed []byte) bool {
+		_, plain, err := e.open(sealed)
+		if err != nil {
+			return true
+		}
+		s.Total++
+		s.Memory += len(plain)
+		return true
+	})
+	return
+}
+
+// Iterate decrypts every stored blob and reports it under its canonical
+// digest — Get's contract — rather than the backend's HMAC index key
+func (e *EncryptedDB) Iterate(fn IterateFunc) {
+	e.backend.Iterate(func(_ cipher.SHA256, sealed []byte) bool {
+		digest, plain, err := e.open(sealed)
+		if err != nil {
+			return true
+		}
+		return fn(digest, plain)
+	})
+}
+
+// Keyfile is a scrypt-derived KEK wrapping a random master key, stored as
+// JSON on disk so OpenEncrypted can be handed nothing but a passphrase
+type Keyfile struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	WrappedKey []byte `json:"wrapped_key"`
+	MAC        []byte `json:"mac"`
+}
+
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+func deriveKEK(passphrase string, salt []byte) (kek [masterKeySize]byte, err error) {
+	raw, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, masterKeySize)
+	if err != nil {
+		return
+	}
+	copy(kek[:], raw)
+	return
+}
+
+// GenerateKeyfile creates a fresh random master key wrapped under passphrase
+func GenerateKeyfile(passphrase string) (kf *Keyfile, err error) {
+	var masterKey [masterKeySize]byte
+	if _, err = io.ReadFull(rand.Reader, masterKey[:]); err != nil {
+		return
+	}
+
+	salt := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+	kek, err := deriveKEK(passphrase, salt)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return
+	}
+	wrapped := make([]byte, masterKeySize)
+	stdcipher.NewCTR(block, nonce).XORKeyStream(wrapped, masterKey[:])
+
+	mac := hmac.New(sha256.New, kek[:])
+	mac.Write(nonce)
+	mac.Write(wrapped)
+
+	kf = &Keyfile{
+		Salt:       salt,
+		Nonce:      nonce,
+		WrappedKey: wrapped,
+		MAC:        mac.Sum(nil),
+	}
+	return
+}
+
+// Unwrap recovers the master key from kf using passphrase
+func (kf *Keyfile) Unwrap(passphrase string) (masterKey [masterKeySize]byte, err error) {
+	kek, err := deriveKEK(passphrase, kf.Salt)
+	if err != nil {
+		return
+	}
+
+	mac := hmac.New(sha256.New, kek[:])
+	mac.Write(kf.Nonce)
+	mac.Write(kf.WrappedKey)
+	if !hmac.Equal(kf.MAC, mac.Sum(nil)) {
+		err = ErrWrongPassphrase
+		return
+	}
+
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return
+	}
+	stdcipher.NewCTR(block, kf.Nonce).XORKeyStream(masterKey[:], kf.WrappedKey)
+	return
+}
+
+// Save writes kf to path as JSON
+func (kf *Keyfile) Save(path string) error {
+	raw, err := json.MarshalIndent(kf, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0600)
+}
+
+// LoadKeyfile reads a Keyfile previously written by Save
+func LoadKeyfile(path string) (kf *Keyfile, err error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	kf = new(Keyfile)
+	err = json.Unmarshal(raw, kf)
+	return
+}
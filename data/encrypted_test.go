@@ -0,0 +1,181 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestEncryptedDBCanonicalKeySpace(t *testing.T) {
+	var master [masterKeySize]byte
+	copy(master[:], []byte("01234567890123456789012345678901"))
+
+	mem := NewMemoryDB()
+	ed := NewEncryptedDB(mem, master)
+
+	plain := []byte("hello, access-controlled world")
+	key := cipher.SumSHA256(plain)
+
+	if err := ed.Add(key, plain); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// Get must return the same plaintext under the canonical digest
+	got, ok := ed.Get(key)
+	if !ok || string(got) != string(plain) {
+		t.Fatalf("Get(%v) = %q, %v; want %q, true", key, got, ok, plain)
+	}
+
+	// the backend must never see the canonical digest as its index key
+	if mem.Has(key) {
+		t.Fatalf("backend stored the blob under its canonical digest; index should be HMAC(masterKey, digest)")
+	}
+
+	// Iterate, Where and Statistic must report the canonical digest and
+	// plaintext, not the backend's HMAC index key and ciphertext
+	seen := map[cipher.SHA256][]byte{}
+	ed.Iterate(func(k cipher.SHA256, v []byte) bool {
+		seen[k] = v
+		return true
+	})
+	if v, ok := seen[key]; !ok {
+		t.Fatalf("Iterate did not report canonical digest %v as a key", key)
+	} else if string(v) != string(plain) {
+		t.Fatalf("Iterate value = %q, want plaintext %q", v, plain)
+	}
+
+	found := ed.Where(func(k cipher.SHA256, v []byte) bool {
+		return string(v) == string(plain)
+	})
+	if len(found) != 1 || found[0] != key {
+		t.Fatalf("Where = %v, want [%v]", found, key)
+	}
+
+	stat := ed.Statistic()
+	if stat.Total != 1 {
+		t.Fatalf("Statistic().Total = %d, want 1", stat.Total)
+	}
+	if stat.Memory != len(plain) {
+		t.Fatalf("Statistic().Memory = %d, want %d (plaintext size)", stat.Memory, len(plain))
+	}
+}
+
+func TestEncryptedDBDeleteIsCanonical(t *testing.T) {
+	var master [masterKeySize]byte
+	copy(master[:], []byte("01234567890123456789012345678901"))
+
+	ed := NewEncryptedDB(NewMemoryDB(), master)
+
+	plain := []byte("ephemeral")
+	key := cipher.SumSHA256(plain)
+	if err := ed.Add(key, plain); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if err := ed.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ed.Has(key) {
+		t.Fatalf("blob still present after Delete(%v)", key)
+	}
+
+	var count int
+	ed.Iterate(func(cipher.SHA256, []byte) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Fatalf("Iterate saw %d entries after Delete, want 0", count)
+	}
+}
+
+// TestKeyfileRoundTrip guards the Keyfile helpers OpenEncrypted relies
+// on: Unwrap with the right passphrase must recover the same master key
+// Generate produced, a Save/LoadKeyfile round trip must not change that,
+// and Unwrap with the wrong passphrase must fail rather than silently
+// returning a bogus key.
+func TestKeyfileRoundTrip(t *testing.T) {
+	kf, err := GenerateKeyfile("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("GenerateKeyfile: %v", err)
+	}
+
+	want, err := kf.Unwrap("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "cxo-keyfile-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "master.key")
+	if err = kf.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := LoadKeyfile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyfile: %v", err)
+	}
+
+	got, err := loaded.Unwrap("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Unwrap after Save/LoadKeyfile: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Unwrap after Save/LoadKeyfile returned a different master key")
+	}
+
+	if _, err = loaded.Unwrap("wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("Unwrap with wrong passphrase err = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+// TestOpenEncryptedRoundTrip guards OpenEncrypted end to end: a blob
+// saved before closing must still be there, under the same passphrase,
+// after the BoltDB file and its keyfile are reopened from scratch, and
+// the wrong passphrase must be refused rather than handed a garbage key.
+func TestOpenEncryptedRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cxo-openencrypted-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.db")
+
+	ed, err := OpenEncrypted(path, "hunter2")
+	if err != nil {
+		t.Fatalf("OpenEncrypted: %v", err)
+	}
+
+	plain := []byte("secret payload")
+	key := ed.Update(plain)
+
+	bdb, ok := ed.backend.(*BoltDB)
+	if !ok {
+		t.Fatalf("OpenEncrypted did not wrap a *BoltDB")
+	}
+	if err = bdb.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := OpenEncrypted(path, "hunter2")
+	if err != nil {
+		t.Fatalf("reopening with the right passphrase: %v", err)
+	}
+	defer reopened.backend.(*BoltDB).Close()
+
+	got, ok := reopened.Get(key)
+	if !ok || string(got) != string(plain) {
+		t.Fatalf("Get after reopen = %q, %v; want %q, true", got, ok, plain)
+	}
+
+	if _, err = OpenEncrypted(path, "wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("OpenEncrypted with wrong passphrase err = %v, want ErrWrongPassphrase", err)
+	}
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/cipher/encoder"
+)
+
+// MemoryDB is an in-memory IDataSource backed by a plain map guarded by
+// a sync.RWMutex. It's the simplest backend: fast, but every restart
+// drops the whole object graph
+type MemoryDB struct {
+	sync.RWMutex
+	data map[cipher.SHA256][]byte
+}
+
+// NewMemoryDB creates an empty in-memory database
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		data: make(map[cipher.SHA256][]byte),
+	}
+}
+
+func (d *MemoryDB) Save(value interface{}) cipher.SHA256 {
+	return d.Update(encoder.Serialize(value))
+}
+
+func (d *MemoryDB) Update(data []byte) cipher.SHA256 {
+	key := createKey(data)
+	if key == (cipher.SHA256{}) || data == nil {
+		panic("Invalid key")
+	}
+	d.Lock()
+	d.data[key] = data
+	d.Unlock()
+	return key
+}
+
+func (d *MemoryDB) Add(key cipher.SHA256, value []byte) (err error) {
+	if key == (cipher.SHA256{}) || value == nil {
+		panic("Invalid key")
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.has(key) {
+		return fmt.Errorf("key already present: %v", key)
+	}
+	d.data[key] = value
+	return
+}
+
+func (d *MemoryDB) has(key cipher.SHA256) (ok bool) {
+	_, ok = d.data[key]
+	return
+}
+
+func (d *MemoryDB) Has(key cipher.SHA256) bool {
+	d.RLock()
+	defer d.RUnlock()
+	return d.has(key)
+}
+
+func (d *MemoryDB) Get(key cipher.SHA256) (v []byte, ok bool) {
+	d.RLock()
+	defer d.RUnlock()
+	v, ok = d.data[key]
+	return
+}
+
+func (d *MemoryDB) Where(q QueryFunc) []cipher.SHA256 {
+	result := []cipher.SHA256{}
+	d.RLock()
+	defer d.RUnlock()
+	for key, value := range d.data {
+		if q(key, value) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func (d *MemoryDB) Statistic() (s Statistic) {
+	d.RLock()
+	defer d.RUnlock()
+	s.Total = len(d.data)
+	for _, v := range d.data {
+		s.Memory += len(v) // + len(cipher.SHA256) ?
+	}
+	return
+}
+
+// Iterate calls fn for every stored key/value pair under the read lock,
+// stopping early if fn returns false
+func (d *MemoryDB) Iterate(fn IterateFunc) {
+	d.RLock()
+	defer d.RUnlock()
+	for key, value := range d.data {
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// Delete removes the blob stored under key, if any
+func (d *MemoryDB) Delete(key cipher.SHA256) error {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.data, key)
+	return nil
+}
@@ -0,0 +1,278 @@
+package skyobject
+
+import (
+	"crypto/aes"
+	stdcipher "crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/cipher/encoder"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// AccessHref is a reference to an object whose blob is stored encrypted.
+// Schema is the schema of the decrypted object; Resolve refuses to
+// decrypt unless this blob is present, the same way a missing schema
+// anywhere else in the href graph is treated as unreachable rather than
+// silently skipped. ObjKey and ACT point to the encrypted object and its
+// access table respectively. Use `skyobject:"href,access,schema=Foo"` on
+// the field that holds one
+type AccessHref struct {
+	Schema cipher.SHA256
+	ObjKey cipher.SHA256
+	ACT    cipher.SHA256
+}
+
+// actEntry wraps the content-encryption key of an AccessHref's object for
+// a single recipient
+type actEntry struct {
+	Recipient  cipher.PubKey
+	WrappedKey []byte
+}
+
+// accessTable is the blob an AccessHref.ACT points to: Owner is the
+// public key that created it (and can Grant/Revoke), Entries is the list
+// of recipients who can currently Resolve the object
+type accessTable struct {
+	Owner   cipher.PubKey
+	Entries []actEntry
+}
+
+// Resolve decrypts the object ref points at for recipientSec, walking
+// ref's access table for a wrapped key addressed to the matching public
+// key. It returns ErrMissingObject if ref.Schema is not present (Resolve
+// won't hand back plaintext we can't attribute to a known schema) and
+// ErrAccessDenied if recipientSec's public key has no entry in the table
+func (c *Container) Resolve(ref AccessHref, recipientSec cipher.SecKey) (data []byte, err error) {
+	if _, ok := c.db.Get(ref.Schema); !ok {
+		err = ErrMissingObject
+		return
+	}
+	actData, ok := c.db.Get(ref.ACT)
+	if !ok {
+		err = ErrMissingObject
+		return
+	}
+	var table accessTable
+	if err = encoder.DeserializeRaw(actData, &table); err != nil {
+		return
+	}
+
+	recipientPub := cipher.PubKeyFromSecKey(recipientSec)
+	for _, e := range table.Entries {
+		if e.Recipient != recipientPub {
+			continue
+		}
+		var cek []byte
+		if cek, err = unwrapKey(e.WrappedKey, table.Owner, recipientSec); err != nil {
+			return
+		}
+		var sealed []byte
+		if sealed, ok = c.db.Get(ref.ObjKey); !ok {
+			err = ErrMissingObject
+			return
+		}
+		return aesGCMOpen(cek, sealed)
+	}
+	err = ErrAccessDenied
+	return
+}
+
+// NewAccess encrypts plain under a fresh content-encryption key and
+// stores it alongside an access table granting ownerSec's own public
+// key, returning the resulting AccessHref for schema
+func (c *Container) NewAccess(schema cipher.SHA256, plain []byte,
+	ownerSec cipher.SecKey) (ref AccessHref, err error) {
+
+	cek := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, cek); err != nil {
+		return
+	}
+	var sealed []byte
+	if sealed, err = aesGCMSeal(cek, plain); err != nil {
+		return
+	}
+
+	ownerPub := cipher.PubKeyFromSecKey(ownerSec)
+	var wrapped []byte
+	if wrapped, err = wrapKey(cek, ownerPub, ownerSec); err != nil {
+		return
+	}
+
+	table := accessTable{
+		Owner: ownerPub,
+		Entries: []actEntry{{
+			Recipient:  ownerPub,
+			WrappedKey: wrapped,
+		}},
+	}
+
+	ref.Schema = schema
+	ref.ObjKey = c.db.Update(sealed) // raw, so Resolve can open what Get returns
+	ref.ACT = c.db.Save(table)
+	return
+}
+
+// Grant extends ref's access table with a new entry for recipientPub,
+// unwrapped and re-wrapped by ownerSec, and returns the resulting
+// AccessHref. ACT is content-addressed, so granting access produces a
+// new ACT key; as with any other reference in this container, the
+// caller must re-Save the containing object with the updated AccessHref
+// to persist the change
+func (c *Container) Grant(ref AccessHref, recipientPub cipher.PubKey,
+	ownerSec cipher.SecKey) (out AccessHref, err error) {
+
+	table, cek, err := c.openTable(ref, ownerSec)
+	if err != nil {
+		return
+	}
+
+	for _, e := range table.Entries {
+		if e.Recipient == recipientPub {
+			out = ref
+			return // already granted
+		}
+	}
+
+	var wrapped []byte
+	if wrapped, err = wrapKey(cek, recipientPub, ownerSec); err != nil {
+		return
+	}
+	table.Entries = append(table.Entries, actEntry{
+		Recipient:  recipientPub,
+		WrappedKey: wrapped,
+	})
+
+	out = ref
+	out.ACT = c.db.Save(table)
+	return
+}
+
+// Revoke removes recipientPub's entry from ref's access table and
+// returns the resulting AccessHref. It doesn't rotate the CEK, so anyone
+// who already unwrapped it before being revoked can still decrypt old
+// copies of the object; re-encrypt under a fresh key via NewAccess and
+// Grant it anew if that matters for your use case
+func (c *Container) Revoke(ref AccessHref, recipientPub cipher.PubKey,
+	ownerSec cipher.SecKey) (out AccessHref, err error) {
+
+	table, _, err := c.openTable(ref, ownerSec)
+	if err != nil {
+		return
+	}
+
+	kept := table.Entries[:0]
+	for _, e := range table.Entries {
+		if e.Recipient != recipientPub {
+			kept = append(kept, e)
+		}
+	}
+	table.Entries = kept
+
+	out = ref
+	out.ACT = c.db.Save(table)
+	return
+}
+
+// openTable loads ref's access table and recovers the content-encryption
+// key using ownerSec, which must match the table's Owner and have its
+// own entry in it
+func (c *Container) openTable(ref AccessHref, ownerSec cipher.SecKey) (table accessTable, cek []byte, err error) {
+	actData, ok := c.db.Get(ref.ACT)
+	if !ok {
+		err = ErrMissingObject
+		return
+	}
+	if err = encoder.DeserializeRaw(actData, &table); err != nil {
+		return
+	}
+
+	ownerPub := cipher.PubKeyFromSecKey(ownerSec)
+	if table.Owner != ownerPub {
+		err = ErrAccessDenied
+		return
+	}
+
+	for _, e := range table.Entries {
+		if e.Recipient != ownerPub {
+			continue
+		}
+		cek, err = unwrapKey(e.WrappedKey, ownerPub, ownerSec)
+		return
+	}
+	err = ErrAccessDenied
+	return
+}
+
+// wrapKey encrypts cek to recipientPub via ECDH(recipientPub, ownerSec)
+// -> HKDF -> AES-GCM
+func wrapKey(cek []byte, recipientPub cipher.PubKey, ownerSec cipher.SecKey) (wrapped []byte, err error) {
+	shared, err := cipher.ECDH(recipientPub, ownerSec)
+	if err != nil {
+		return
+	}
+	kek, err := hkdfKey(shared)
+	if err != nil {
+		return
+	}
+	return aesGCMSeal(kek, cek)
+}
+
+// unwrapKey reverses wrapKey from the recipient's side: recipientSec's
+// ECDH with ownerPub yields the same shared secret ownerSec derived it
+// with on the other end
+func unwrapKey(wrapped []byte, ownerPub cipher.PubKey, recipientSec cipher.SecKey) (cek []byte, err error) {
+	shared, err := cipher.ECDH(ownerPub, recipientSec)
+	if err != nil {
+		return
+	}
+	kek, err := hkdfKey(shared)
+	if err != nil {
+		return
+	}
+	return aesGCMOpen(kek, wrapped)
+}
+
+func hkdfKey(shared []byte) (key []byte, err error) {
+	key = make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, nil, []byte("cxo-access")), key)
+	return
+}
+
+func aesGCMSeal(key, plain []byte) (sealed []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	gcm, err := stdcipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	sealed = gcm.Seal(nonce, nonce, plain, nil)
+	return
+}
+
+func aesGCMOpen(key, sealed []byte) (plain []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	gcm, err := stdcipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	if len(sealed) < gcm.NonceSize() {
+		err = errors.New("skyobject: malformed ciphertext")
+		return
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
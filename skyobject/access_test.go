@@ -0,0 +1,72 @@
+package skyobject
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skycoin/cxo/data"
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// TestAccessGrantThenResolve is the round-trip the access-control feature
+// exists for: Resolve must fail for a recipient with no table entry,
+// succeed once Grant adds one, and fail again once Revoke removes it.
+func TestAccessGrantThenResolve(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := NewContainer(db)
+
+	schema := db.Save([]byte("schema blob"))
+	_, ownerSec := cipher.GenerateKeyPair()
+	recipientPub, recipientSec := cipher.GenerateKeyPair()
+
+	ref, err := c.NewAccess(schema, []byte("secret payload"), ownerSec)
+	if err != nil {
+		t.Fatalf("NewAccess: %v", err)
+	}
+
+	if _, err = c.Resolve(ref, recipientSec); err != ErrAccessDenied {
+		t.Fatalf("Resolve before Grant err = %v, want ErrAccessDenied", err)
+	}
+
+	if ref, err = c.Grant(ref, recipientPub, ownerSec); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+
+	plain, err := c.Resolve(ref, recipientSec)
+	if err != nil {
+		t.Fatalf("Resolve after Grant: %v", err)
+	}
+	if !bytes.Equal(plain, []byte("secret payload")) {
+		t.Fatalf("Resolve returned %q, want %q", plain, "secret payload")
+	}
+
+	if ref, err = c.Revoke(ref, recipientPub, ownerSec); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err = c.Resolve(ref, recipientSec); err != ErrAccessDenied {
+		t.Fatalf("Resolve after Revoke err = %v, want ErrAccessDenied", err)
+	}
+}
+
+// TestAccessResolveMissingSchema guards against Resolve handing back
+// plaintext for an AccessHref whose Schema blob isn't actually in the
+// container: a caller has no way to interpret such data, so it must be
+// treated the same as any other missing schema in the href graph.
+func TestAccessResolveMissingSchema(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := NewContainer(db)
+
+	schema := db.Save([]byte("schema blob"))
+	_, ownerSec := cipher.GenerateKeyPair()
+
+	ref, err := c.NewAccess(schema, []byte("secret payload"), ownerSec)
+	if err != nil {
+		t.Fatalf("NewAccess: %v", err)
+	}
+
+	ref.Schema = cipher.SHA256{} // a schema key nothing ever Saved
+
+	if _, err = c.Resolve(ref, ownerSec); err != ErrMissingObject {
+		t.Fatalf("Resolve with missing schema err = %v, want ErrMissingObject", err)
+	}
+}
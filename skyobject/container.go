@@ -16,6 +16,7 @@ var (
 	hrefTypeName        = typeName(reflect.TypeOf(cipher.SHA256{}))
 	hrefArrayTypeName   = typeName(reflect.TypeOf([]cipher.SHA256{}))
 	dynamicHrefTypeName = typeName(reflect.TypeOf(DynamicHref{}))
+	accessHrefTypeName  = typeName(reflect.TypeOf(AccessHref{}))
 
 	// ErrMissingRoot occurs when a Container doesn't have
 	// a root object, but the action requires it
@@ -26,6 +27,9 @@ var (
 	// ErrMissingSchemaName occurs when a field has got `skyobject:"href"`
 	// tag, but doesn't have "schema=xxx" tag
 	ErrMissingSchemaName = errors.New("missing schema name")
+	// ErrMissingAccessTag occurs when a field is of type AccessHref but
+	// its `skyobject:"href"` tag is missing the "access" marker
+	ErrMissingAccessTag = errors.New("missing access tag")
 	// ErrMissingObject occurs where requested object is not received yet
 	ErrMissingObject = errors.New("missing object")
 	// ErrInvalidArgument occurs when given argument is not valid
@@ -33,6 +37,9 @@ var (
 	// ErrMalformedRoot can occur during SetRoot call if the given
 	// root is malformed
 	ErrMalformedRoot = errors.New("malformed root")
+	// ErrAccessDenied occurs when Resolve, Grant or Revoke is called
+	// with a key that has no entry in the relevant access table
+	ErrAccessDenied = errors.New("access denied")
 
 	// ErrStopInspection is used to stop Inspect
 	ErrStopInspection = errors.New("stop inspection")
@@ -41,13 +48,14 @@ var (
 // A Container is a helper type to manage skyobjects. The container is not
 // thread safe
 type Container struct {
-	db   *data.DB
-	root *Root
+	db     data.IDataSource
+	root   *Root
+	pinned []*Root // extra generations GC must not collect, see Pin
 }
 
-// NewContainer creates new Container that will use provided database.
-// The database must not be nil
-func NewContainer(db *data.DB) (c *Container) {
+// NewContainer creates new Container that will use provided data source.
+// The data source must not be nil
+func NewContainer(db data.IDataSource) (c *Container) {
 	if db == nil {
 		panic("NewContainer tooks in nil-db")
 	}
@@ -90,10 +98,29 @@ func (c *Container) SetEncodedRoot(data []byte) (ok bool, err error) {
 	return
 }
 
+// EncodeRoot serializes root the way SetEncodedRoot expects to decode it,
+// so a Root can be announced to a peer (see skyobject/sync)
+func EncodeRoot(root *Root) []byte {
+	return encoder.Serialize(root)
+}
+
+// Get returns the value stored under key, if any. It's a thin pass
+// through to the underlying data source, primarily useful to transports
+// like skyobject/sync that need to read raw blobs out of a Container
+func (c *Container) Get(key cipher.SHA256) ([]byte, bool) {
+	return c.db.Get(key)
+}
+
+// Add stores value under key in the underlying data source, as long as
+// key isn't already present
+func (c *Container) Add(key cipher.SHA256, value []byte) error {
+	return c.db.Add(key, value)
+}
+
 // Save serializes given object and sotres it in DB returning
 // key of the object
 func (c *Container) Save(i interface{}) cipher.SHA256 {
-	return c.db.AddAutoKey(encoder.Serialize(i))
+	return c.db.Save(i)
 }
 
 // SaveArray saves array of objects and retursn references to them
@@ -117,8 +144,51 @@ func (c *Container) Want() (want map[cipher.SHA256]struct{}, err error) {
 	return c.want(c.root.Schema, c.root.Root)
 }
 
-func (c *Container) want(schk,
-	objk cipher.SHA256) (want map[cipher.SHA256]struct{}, err error) {
+func (c *Container) want(schk, objk cipher.SHA256) (want map[cipher.SHA256]struct{}, err error) {
+	want = make(map[cipher.SHA256]struct{})
+
+	noop := func(cipher.SHA256) error { return nil }
+
+	// a missing schema also makes the object itself unreachable, so
+	// both are recorded as wanted (matching the old, non-shared logic)
+	onMissingSchema := func(schk, objk cipher.SHA256) error {
+		want[schk] = struct{}{}
+		if _, ok := c.db.Get(objk); !ok {
+			want[objk] = struct{}{}
+		}
+		return nil
+	}
+	onMissingObject := func(objk cipher.SHA256) error {
+		want[objk] = struct{}{}
+		return nil
+	}
+
+	seen := make(map[[2]cipher.SHA256]struct{})
+	if err = c.walkRefs(schk, objk, seen, noop, onMissingSchema, onMissingObject); err != nil {
+		want = nil
+	}
+	return
+}
+
+// walkRefs walks the href graph rooted at (schk, objk): the schema, the
+// object, and every cipher.SHA256, []cipher.SHA256 and DynamicHref field
+// the schema declares, recursively. It underlies both Want (which treats
+// a missing schema or object as "add it to the wanted set and stop
+// descending") and GC (which treats it as a hard error, since a subgraph
+// we can't fully inspect can't be safely pruned). seen prevents cycles
+// from recursing forever; it's keyed by the (schema, object) pair because
+// the same object can legitimately appear under more than one schema
+func (c *Container) walkRefs(schk, objk cipher.SHA256,
+	seen map[[2]cipher.SHA256]struct{},
+	visit func(key cipher.SHA256) error,
+	onMissingSchema func(schk, objk cipher.SHA256) error,
+	onMissingObject func(objk cipher.SHA256) error) (err error) {
+
+	pair := [2]cipher.SHA256{schk, objk}
+	if _, ok := seen[pair]; ok {
+		return
+	}
+	seen[pair] = struct{}{}
 
 	var (
 		schd, objd []byte
@@ -127,16 +197,17 @@ func (c *Container) want(schk,
 		s Schema
 	)
 
-	want = make(map[cipher.SHA256]struct{})
-
 	if schd, ok = c.db.Get(schk); !ok { // don't have the schema
-		want[schk] = struct{}{}
-		c.addMissing(want, objk)
+		return onMissingSchema(schk, objk)
+	}
+	if err = visit(schk); err != nil {
 		return
 	}
 
 	if objd, ok = c.db.Get(objk); !ok {
-		want[objk] = struct{}{}
+		return onMissingObject(objk)
+	}
+	if err = visit(objk); err != nil {
 		return
 	}
 
@@ -157,75 +228,163 @@ func (c *Container) want(schk,
 		case hrefTypeName:
 			// the field contains cipher.SHA256 reference
 			var ref cipher.SHA256
-			err = encoder.DeserializeField(objd, s.Fields, sf.Name, &ref)
-			if err != nil {
-				goto Error
+			if err = encoder.DeserializeField(objd, s.Fields, sf.Name, &ref); err != nil {
+				return
 			}
-			if schk, err = c.schemaByTag(tag); err != nil {
-				goto Error
+			var fsk cipher.SHA256
+			if fsk, err = c.schemaByTag(tag); err != nil {
+				return
 			}
-			var w map[cipher.SHA256]struct{}
-			if w, err = c.want(schk, ref); err != nil {
-				goto Error
+			if err = c.walkRefs(fsk, ref, seen, visit, onMissingSchema, onMissingObject); err != nil {
+				return
 			}
-			mergeMaps(want, w)
 		case hrefArrayTypeName:
 			// the field contains []cipher.SHA256 references
 			var refs []cipher.SHA256
-			err = encoder.DeserializeField(objd, s.Fields, sf.Name, &refs)
-			if err != nil {
-				goto Error
+			if err = encoder.DeserializeField(objd, s.Fields, sf.Name, &refs); err != nil {
+				return
 			}
-			if schk, err = c.schemaByTag(tag); err != nil {
-				goto Error
+			var fsk cipher.SHA256
+			if fsk, err = c.schemaByTag(tag); err != nil {
+				return
 			}
-			var w map[cipher.SHA256]struct{}
 			for _, ref := range refs {
-				if w, err = c.want(schk, ref); err != nil {
-					goto Error
+				if err = c.walkRefs(fsk, ref, seen, visit, onMissingSchema, onMissingObject); err != nil {
+					return
 				}
-				mergeMaps(want, w)
 			}
 		case dynamicHrefTypeName:
 			// the field refer to dynamic schema
 			var dh DynamicHref
-			err = encoder.DeserializeField(objd, s.Fields, sf.Name, &dh)
-			if err != nil {
-				goto Error
+			if err = encoder.DeserializeField(objd, s.Fields, sf.Name, &dh); err != nil {
+				return
+			}
+			if err = c.walkRefs(dh.Schema, dh.ObjKey, seen, visit, onMissingSchema, onMissingObject); err != nil {
+				return
 			}
-			var w map[cipher.SHA256]struct{}
-			if w, err = c.want(dh.Schema, dh.ObjKey); err != nil {
-				goto Error
+		case accessHrefTypeName:
+			// the field refers to an access-controlled object: the
+			// object and its ACT blobs are opaque ciphertext without the
+			// recipient's key, and ah.Schema can't be walked without
+			// decrypting the object it describes, so all three are
+			// wanted as leaves rather than walked like a plain href
+			if !strings.Contains(tag, "access") {
+				return ErrMissingAccessTag
+			}
+			var ah AccessHref
+			if err = encoder.DeserializeField(objd, s.Fields, sf.Name, &ah); err != nil {
+				return
+			}
+			for _, leaf := range [3]cipher.SHA256{ah.ObjKey, ah.ACT, ah.Schema} {
+				if _, ok := c.db.Get(leaf); !ok {
+					if err = onMissingObject(leaf); err != nil {
+						return
+					}
+					continue
+				}
+				if err = visit(leaf); err != nil {
+					return
+				}
 			}
-			mergeMaps(want, w)
 		default:
-			err = ErrUnexpectedHrefTag
-			goto Error
+			return ErrUnexpectedHrefTag
 		}
 	}
 	return
-Error:
-	want = nil // set want to nil if we have got an error
+}
+
+// GCStats is returned by GC and reports how much work it did
+type GCStats struct {
+	Scanned    int // blobs inspected in the underlying data source
+	Reachable  int // distinct keys reachable from Roots()
+	Removed    int // blobs actually deleted
+	BytesFreed int // total size of the removed blobs
+}
+
+// Roots returns every root object GC must keep reachable: the current
+// root plus any generation pinned with Pin. Applications that keep a
+// history of roots (snapshots) should Pin every generation they want to
+// survive garbage collection
+func (c *Container) Roots() (roots []*Root) {
+	roots = make([]*Root, 0, len(c.pinned)+1)
+	if c.root != nil {
+		roots = append(roots, c.root)
+	}
+	roots = append(roots, c.pinned...)
 	return
 }
 
-// mergeMaps merges appention to dst
-func mergeMaps(dst, appention map[cipher.SHA256]struct{}) {
-	for k := range appention {
-		dst[k] = struct{}{}
+// Pin keeps root (and everything reachable from it) alive across GC even
+// after it stops being the current root
+func (c *Container) Pin(root *Root) {
+	c.pinned = append(c.pinned, root)
+}
+
+// Unpin reverses a previous Pin
+func (c *Container) Unpin(root *Root) {
+	for i, r := range c.pinned {
+		if r == root {
+			c.pinned = append(c.pinned[:i], c.pinned[i+1:]...)
+			return
+		}
 	}
 }
 
-// append key to array if it is not exist in db
-func (c *Container) addMissing(w map[cipher.SHA256]struct{},
-	keys ...cipher.SHA256) {
+// GC removes every blob that is not reachable from Roots(). It returns a
+// non-nil error, with nothing removed, if it encounters a subgraph it
+// can't fully inspect (a missing schema or object), since we can't be
+// sure what's safe to drop in that case
+func (c *Container) GC() (stats GCStats, err error) {
+	roots := c.Roots()
+	if len(roots) == 0 {
+		// no root to anchor reachability on: everything would look
+		// unreachable and GC would wipe the whole database
+		err = ErrMissingRoot
+		return
+	}
+
+	reachable := make(map[cipher.SHA256]struct{})
+	seen := make(map[[2]cipher.SHA256]struct{})
+
+	visit := func(key cipher.SHA256) error {
+		reachable[key] = struct{}{}
+		return nil
+	}
+	onMissingSchema := func(schk, objk cipher.SHA256) error {
+		return fmt.Errorf("GC: missing schema %s, can't inspect subgraph", schk.Hex())
+	}
+	onMissingObject := func(objk cipher.SHA256) error {
+		return fmt.Errorf("GC: missing object %s, can't inspect subgraph", objk.Hex())
+	}
 
-	for _, key := range keys {
-		if _, ok := c.db.Get(key); !ok {
-			w[key] = struct{}{}
+	for _, root := range roots {
+		reachable[root.Schema] = struct{}{}
+		for _, schk := range root.registry {
+			reachable[schk] = struct{}{}
+		}
+		if err = c.walkRefs(root.Schema, root.Root, seen, visit, onMissingSchema, onMissingObject); err != nil {
+			return
 		}
 	}
+	stats.Reachable = len(reachable)
+
+	var toRemove []cipher.SHA256
+	c.db.Iterate(func(key cipher.SHA256, value []byte) bool {
+		stats.Scanned++
+		if _, ok := reachable[key]; !ok {
+			toRemove = append(toRemove, key)
+			stats.BytesFreed += len(value)
+		}
+		return true
+	})
 
+	for _, key := range toRemove {
+		if err = c.db.Delete(key); err != nil {
+			return
+		}
+		stats.Removed++
+	}
+	return
 }
 
 // get vlaue of `skyobjet:"xxx"` tag or empty string
@@ -0,0 +1,140 @@
+package skyobject
+
+import (
+	"testing"
+
+	"github.com/skycoin/cxo/data"
+)
+
+// TestGCWithoutRootIsANoOp guards against GC treating "no root, nothing
+// pinned" as "nothing is reachable" and sweeping the whole database.
+func TestGCWithoutRootIsANoOp(t *testing.T) {
+	db := data.NewMemoryDB()
+	key := db.Update([]byte("unrelated blob"))
+
+	c := NewContainer(db)
+	// c.root is nil and nothing has been pinned
+
+	stats, err := c.GC()
+	if err != ErrMissingRoot {
+		t.Fatalf("GC() err = %v, want ErrMissingRoot", err)
+	}
+	if stats != (GCStats{}) {
+		t.Fatalf("GC() stats = %+v, want the zero value", stats)
+	}
+	if !db.Has(key) {
+		t.Fatalf("GC() deleted a blob from a container with no root; want a no-op")
+	}
+}
+
+// TestGCRemovesUnreachableKeepsReachable guards the core contract: a
+// blob reachable from the current root must survive GC, and one that
+// isn't must be removed and accounted for in GCStats.
+func TestGCRemovesUnreachableKeepsReachable(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := NewContainer(db)
+
+	schemaKey := db.Save(Schema{})
+	objKey := db.Save([]byte("root object"))
+	c.SetRoot(&Root{Schema: schemaKey, Root: objKey, Time: 1})
+
+	orphan := db.Update([]byte("orphaned blob"))
+
+	stats, err := c.GC()
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if db.Has(orphan) {
+		t.Fatalf("GC left an unreachable blob in place")
+	}
+	if !db.Has(schemaKey) || !db.Has(objKey) {
+		t.Fatalf("GC removed a blob reachable from the root")
+	}
+	if stats.Removed != 1 {
+		t.Fatalf("GC stats.Removed = %d, want 1", stats.Removed)
+	}
+	if stats.Reachable != 2 {
+		t.Fatalf("GC stats.Reachable = %d, want 2 (schema + object)", stats.Reachable)
+	}
+	if stats.BytesFreed != len("orphaned blob") {
+		t.Fatalf("GC stats.BytesFreed = %d, want %d", stats.BytesFreed, len("orphaned blob"))
+	}
+}
+
+// TestGCSeenSkipsARepeatedSchemaObjectPair covers the same (schema,
+// object) pair showing up twice in one GC pass (here, because the same
+// root is both current and pinned): GC must not treat it as an error and
+// must count its blobs once in GCStats.Reachable. Reachable is a set, so
+// this does not by itself show the pair was walked only once.
+func TestGCSeenSkipsARepeatedSchemaObjectPair(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := NewContainer(db)
+
+	schemaKey := db.Save(Schema{})
+	objKey := db.Save([]byte("shared object"))
+	root := &Root{Schema: schemaKey, Root: objKey, Time: 1}
+
+	c.SetRoot(root)
+	c.Pin(root) // same pair now appears twice in c.Roots()
+
+	stats, err := c.GC()
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if !db.Has(schemaKey) || !db.Has(objKey) {
+		t.Fatalf("GC removed a blob reachable from a pinned+current root")
+	}
+	if stats.Reachable != 2 {
+		t.Fatalf("GC stats.Reachable = %d, want 2 (not double-counted)", stats.Reachable)
+	}
+}
+
+// TestGCPinKeepsOldRootAliveUntilUnpin guards Pin/Unpin/Roots: a root
+// that stops being current must still survive GC while pinned, and
+// become collectible again once Unpin'd.
+func TestGCPinKeepsOldRootAliveUntilUnpin(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := NewContainer(db)
+
+	// both generations share one schema (as different snapshots of the
+	// same registered type commonly would); only the object differs, so
+	// reachability of oldObj/newObj is what distinguishes the two roots
+	schemaKey := db.Save(Schema{})
+
+	oldObj := db.Update([]byte("old generation"))
+	oldRoot := &Root{Schema: schemaKey, Root: oldObj, Time: 1}
+	c.SetRoot(oldRoot)
+	c.Pin(oldRoot)
+
+	newObj := db.Update([]byte("new generation"))
+	c.SetRoot(&Root{Schema: schemaKey, Root: newObj, Time: 2})
+
+	if len(c.Roots()) != 2 {
+		t.Fatalf("Roots() = %d entries, want 2 (current + pinned)", len(c.Roots()))
+	}
+
+	if _, err := c.GC(); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if !db.Has(oldObj) {
+		t.Fatalf("GC collected a pinned generation")
+	}
+	if !db.Has(newObj) {
+		t.Fatalf("GC collected the current root")
+	}
+
+	c.Unpin(oldRoot)
+	if len(c.Roots()) != 1 {
+		t.Fatalf("Roots() = %d entries after Unpin, want 1 (current only)", len(c.Roots()))
+	}
+
+	if _, err := c.GC(); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if db.Has(oldObj) {
+		t.Fatalf("GC kept an unpinned, no-longer-current generation alive")
+	}
+	if !db.Has(newObj) {
+		t.Fatalf("GC collected the current root")
+	}
+}
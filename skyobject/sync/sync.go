@@ -0,0 +1,339 @@
+// Package sync implements a pull-based replication protocol between two
+// skyobject.Container instances, built directly on top of Container.Want.
+package sync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/cipher/encoder"
+
+	"github.com/skycoin/cxo/skyobject"
+)
+
+// frame type tags, written as the first byte of every message
+const (
+	msgRootAnnounce byte = iota
+	msgWantRequest
+	msgHaveChunk
+	msgDone
+)
+
+// maxFrameSize guards against a peer claiming an absurd frame length
+const maxFrameSize = 64 * 1024 * 1024
+
+// DefaultWindow bounds how many outstanding chunk requests Sync keeps in
+// flight to its peer at once
+const DefaultWindow = 64
+
+// RootAnnounce carries an encoded Root (see skyobject.EncodeRoot) so the
+// peer can adopt it if it's newer. An empty Root means "I have no root yet"
+type RootAnnounce struct {
+	Root []byte
+}
+
+// WantRequest carries the set of keys the requester is missing, tagged
+// with the round it belongs to so the peer can echo that round back on
+// every reply. An empty Keys means "I have nothing left to ask for"
+type WantRequest struct {
+	Round uint32
+	Keys  []cipher.SHA256
+}
+
+// HaveChunk carries a single requested (key, value) pair, tagged with
+// the WantRequest round it answers
+type HaveChunk struct {
+	Round uint32
+	Key   cipher.SHA256
+	Value []byte
+}
+
+// Done signals that the sender has nothing further to send for the
+// WantRequest round it's tagged with
+type Done struct {
+	Round uint32
+}
+
+// Verifier rejects blobs whose content doesn't match the key the sender
+// claimed for it
+type Verifier func(key cipher.SHA256, value []byte) error
+
+// DefaultVerifier checks that key is the SHA256 digest of value
+func DefaultVerifier(key cipher.SHA256, value []byte) error {
+	if cipher.SumSHA256(value) != key {
+		return fmt.Errorf("sync: value does not hash to claimed key %s", key.Hex())
+	}
+	return nil
+}
+
+// Sync runs one replication session against peer over rw, using
+// DefaultWindow and verify (DefaultVerifier if verify is nil). Both ends
+// should call Sync (or
+// SyncWindow) concurrently on their respective ends of the same
+// connection
+func Sync(rw io.ReadWriter, c *skyobject.Container, verify Verifier) error {
+	return SyncWindow(rw, c, verify, DefaultWindow)
+}
+
+// SyncWindow is Sync with an explicit bound on in-flight chunk requests.
+// It first exchanges roots (the side with the older Root adopts the
+// newer one via Container.SetEncodedRoot), then repeatedly calls
+// Container.Want and requests whatever comes back while also serving
+// whatever the peer asks of it, until both sides run dry. Fetching a
+// schema can uncover references one hop deeper, so Want is re-evaluated
+// every round rather than once up front
+func SyncWindow(rw io.ReadWriter, c *skyobject.Container, verify Verifier, window int) error {
+	if verify == nil {
+		verify = DefaultVerifier
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	s := &session{
+		rw:     rw,
+		c:      c,
+		verify: verify,
+		window: window,
+		roots:  make(chan RootAnnounce, 1),
+		wants:  make(chan WantRequest, 1),
+		chunks: make(chan HaveChunk, window),
+		dones:  make(chan Done, 1),
+		done:   make(chan struct{}),
+	}
+
+	go s.readLoop()
+
+	if err := s.announceRoot(); err != nil {
+		return err
+	}
+	if err := s.adoptRoot(); err != nil {
+		return err
+	}
+
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- s.serve() }()
+
+	if err := s.pull(); err != nil {
+		return err
+	}
+	return <-serveDone
+}
+
+// session demultiplexes one underlying io.ReadWriter into the four frame
+// types both the pull loop and the serve loop need to read
+type session struct {
+	rw     io.ReadWriter
+	wmu    sync.Mutex
+	c      *skyobject.Container
+	verify Verifier
+	window int
+
+	roots  chan RootAnnounce
+	wants  chan WantRequest
+	chunks chan HaveChunk
+	dones  chan Done
+
+	// pull and serve run concurrently and both need to learn about a
+	// readLoop failure, so it's broadcast by closing done rather than
+	// handed out over a channel only one of them could ever receive
+	failOnce sync.Once
+	failErr  error
+	done     chan struct{}
+}
+
+func (s *session) fail(err error) {
+	s.failOnce.Do(func() {
+		s.failErr = err
+		close(s.done)
+	})
+}
+
+func (s *session) write(tag byte, value interface{}) error {
+	body := encoder.Serialize(value)
+	header := make([]byte, 5)
+	header[0] = tag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(body)))
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if _, err := s.rw.Write(header); err != nil {
+		return err
+	}
+	_, err := s.rw.Write(body)
+	return err
+}
+
+// readLoop reads length-prefixed, encoder.Serialize'd frames off rw and
+// routes each one onto its type's channel, so pull and serve can each
+// read only what concerns them
+func (s *session) readLoop() {
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(s.rw, header); err != nil {
+			s.fail(err)
+			return
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > maxFrameSize {
+			s.fail(errors.New("sync: frame too large"))
+			return
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(s.rw, body); err != nil {
+			s.fail(err)
+			return
+		}
+
+		switch header[0] {
+		case msgRootAnnounce:
+			var m RootAnnounce
+			if err := encoder.DeserializeRaw(body, &m); err != nil {
+				s.fail(err)
+				return
+			}
+			s.roots <- m
+		case msgWantRequest:
+			var m WantRequest
+			if err := encoder.DeserializeRaw(body, &m); err != nil {
+				s.fail(err)
+				return
+			}
+			s.wants <- m
+		case msgHaveChunk:
+			var m HaveChunk
+			if err := encoder.DeserializeRaw(body, &m); err != nil {
+				s.fail(err)
+				return
+			}
+			s.chunks <- m
+		case msgDone:
+			var m Done
+			if err := encoder.DeserializeRaw(body, &m); err != nil {
+				s.fail(err)
+				return
+			}
+			s.dones <- m
+		default:
+			s.fail(fmt.Errorf("sync: unknown frame tag %d", header[0]))
+			return
+		}
+	}
+}
+
+func (s *session) announceRoot() error {
+	root := s.c.Root()
+	if root == nil {
+		return s.write(msgRootAnnounce, RootAnnounce{})
+	}
+	return s.write(msgRootAnnounce, RootAnnounce{Root: skyobject.EncodeRoot(root)})
+}
+
+func (s *session) adoptRoot() error {
+	select {
+	case m := <-s.roots:
+		if len(m.Root) == 0 {
+			return nil
+		}
+		_, err := s.c.SetEncodedRoot(m.Root)
+		return err
+	case <-s.done:
+		return s.failErr
+	}
+}
+
+// pull repeatedly asks the peer for everything Container.Want reports
+// missing, one bounded window of keys at a time. Every round is tagged
+// with a sequence number so a Done (or HaveChunk) that arrives late for
+// a round pull has already moved past can't be mistaken for this round's
+func (s *session) pull() error {
+	var round uint32
+	for {
+		want, err := s.c.Want()
+		if err != nil {
+			return err
+		}
+		if len(want) == 0 {
+			// nothing left to ask for; tell the peer so its serve loop
+			// can stop waiting on us
+			return s.write(msgWantRequest, WantRequest{Round: round})
+		}
+
+		keys := make([]cipher.SHA256, 0, s.window)
+		for k := range want {
+			keys = append(keys, k)
+			if len(keys) == s.window {
+				break
+			}
+		}
+
+		if err = s.write(msgWantRequest, WantRequest{Round: round, Keys: keys}); err != nil {
+			return err
+		}
+
+		pending := make(map[cipher.SHA256]struct{}, len(keys))
+		for _, k := range keys {
+			pending[k] = struct{}{}
+		}
+		for len(pending) > 0 {
+			select {
+			case chunk := <-s.chunks:
+				if chunk.Round != round {
+					continue // answers a round we've already moved past
+				}
+				if _, asked := pending[chunk.Key]; !asked {
+					continue // unsolicited, ignore
+				}
+				if err = s.verify(chunk.Key, chunk.Value); err != nil {
+					return err
+				}
+				if err = s.c.Add(chunk.Key, chunk.Value); err != nil {
+					return err
+				}
+				delete(pending, chunk.Key)
+			case done := <-s.dones:
+				if done.Round != round {
+					continue // stale signal from a round we already closed out
+				}
+				// the peer didn't have everything we asked for this
+				// round; Want will ask again next round for whatever's
+				// still missing
+				pending = nil
+			case <-s.done:
+				return s.failErr
+			}
+		}
+		round++
+	}
+}
+
+// serve answers WantRequests from the peer with whatever chunks this
+// Container actually has, until the peer signals it's done asking
+func (s *session) serve() error {
+	for {
+		select {
+		case want := <-s.wants:
+			if len(want.Keys) == 0 {
+				return nil
+			}
+			for _, key := range want.Keys {
+				value, ok := s.c.Get(key)
+				if !ok {
+					continue
+				}
+				if err := s.write(msgHaveChunk, HaveChunk{Round: want.Round, Key: key, Value: value}); err != nil {
+					return err
+				}
+			}
+			if err := s.write(msgDone, Done{Round: want.Round}); err != nil {
+				return err
+			}
+		case <-s.done:
+			return s.failErr
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/cxo/data"
+	"github.com/skycoin/cxo/skyobject"
+)
+
+// pipeConn adapts a pair of *io.Pipe halves into a single io.ReadWriter,
+// so two Containers can run SyncWindow against each other purely in
+// memory, with no real network involved
+type pipeConn struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *pipeConn) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *pipeConn) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+// TestSyncWindowLoopback runs a full replication session between two
+// in-memory Containers over a pair of connected io.Pipes: the sender has
+// a root the receiver doesn't, and once SyncWindow returns on both
+// ends, the receiver should have adopted that root and fetched every
+// blob it points at.
+func TestSyncWindowLoopback(t *testing.T) {
+	sender := data.NewMemoryDB()
+	schemaKey := sender.Save(skyobject.Schema{})
+	objKey := sender.Save([]byte("leaf payload"))
+
+	cSender := skyobject.NewContainer(sender)
+	cSender.SetRoot(&skyobject.Root{Schema: schemaKey, Root: objKey, Time: 1})
+
+	receiver := data.NewMemoryDB()
+	cReceiver := skyobject.NewContainer(receiver)
+
+	senderToReceiver, receiverReadsFromSender := io.Pipe()
+	receiverToSender, senderReadsFromReceiver := io.Pipe()
+	connSender := &pipeConn{r: senderReadsFromReceiver, w: senderToReceiver}
+	connReceiver := &pipeConn{r: receiverReadsFromSender, w: receiverToSender}
+
+	errs := make(chan error, 2)
+	go func() { errs <- SyncWindow(connSender, cSender, nil, DefaultWindow) }()
+	go func() { errs <- SyncWindow(connReceiver, cReceiver, nil, DefaultWindow) }()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("SyncWindow: %v", err)
+		}
+	}
+
+	if cReceiver.Root() == nil || cReceiver.Root().Root != objKey {
+		t.Fatalf("receiver did not adopt the sender's root")
+	}
+	if !receiver.Has(schemaKey) || !receiver.Has(objKey) {
+		t.Fatalf("receiver is missing blobs after sync: schema=%v object=%v",
+			receiver.Has(schemaKey), receiver.Has(objKey))
+	}
+
+	want, err := cReceiver.Want()
+	if err != nil {
+		t.Fatalf("Want after sync: %v", err)
+	}
+	if len(want) != 0 {
+		t.Fatalf("receiver still wants %v after a completed sync", want)
+	}
+}
+
+// TestSyncWindowUnblocksOnPeerDisconnect guards against the failure mode
+// where pull and serve each select on a single-delivery error channel: a
+// readLoop failure must reach both of them, not just whichever happens
+// to receive it first. We give ourselves a root whose schema and object
+// are both missing, so pull is genuinely blocked waiting on chunks that
+// will never arrive and serve is genuinely blocked waiting on a want
+// that will never arrive, then sever the connection exactly once the
+// peer has gone quiet — the same shape as a real peer dropping mid-sync.
+func TestSyncWindowUnblocksOnPeerDisconnect(t *testing.T) {
+	db := data.NewMemoryDB()
+	c := skyobject.NewContainer(db)
+	c.SetRoot(&skyobject.Root{
+		Schema: cipher.SumSHA256([]byte("missing schema")),
+		Root:   cipher.SumSHA256([]byte("missing object")),
+		Time:   1,
+	})
+
+	outR, outW := io.Pipe() // our outgoing frames land here; just drain them
+	inR, inW := io.Pipe()   // our incoming frames come from here
+
+	go io.Copy(ioutil.Discard, outR)
+
+	// hand-deliver a single "I have no root" announce, the same frame a
+	// live peer would send on its end of SyncWindow, then go silent —
+	// simulating a peer that disconnects right after the root exchange.
+	// This runs in its own goroutine because io.Pipe is unbuffered: the
+	// Write below rendezvous with SyncWindow's readLoop, started below
+	go func() {
+		peer := &session{rw: &pipeConn{w: inW}}
+		if err := peer.write(msgRootAnnounce, RootAnnounce{}); err != nil {
+			return
+		}
+		inW.Close()
+	}()
+
+	conn := &pipeConn{r: inR, w: outW}
+
+	done := make(chan error, 1)
+	go func() { done <- SyncWindow(conn, c, nil, DefaultWindow) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("SyncWindow returned nil after the peer disconnected, want the read error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("SyncWindow did not return after the peer disconnected: pull or serve is stuck on the dead session")
+	}
+}